@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// AlertSeverity is the state of a per-(domain,secondary) alert state
+// machine, ordered from least to most severe.
+type AlertSeverity string
+
+const (
+	AlertOK   AlertSeverity = "ok"
+	AlertWarn AlertSeverity = "warn"
+	AlertCrit AlertSeverity = "crit"
+)
+
+var (
+	maxLagSecondsWarn              = 0
+	maxLagSecondsCrit              = 0
+	minRespondingSecondaries       = 0
+	consecutiveFailuresBeforeAlert = 1
+	alertsListen                   = ""
+
+	notifiers MultiNotifier
+
+	alertStatesM sync.Mutex
+	alertStates  = make(map[alertKey]*alertState)
+)
+
+type alertKey struct {
+	domain    string
+	secondary string
+}
+
+type alertState struct {
+	severity     AlertSeverity
+	pending      AlertSeverity
+	pendingCount int
+}
+
+func atoiEnvDefault(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return n
+}
+
+func loadAlertingConfig() {
+	maxLagSecondsWarn = atoiEnvDefault("MAX_LAG_SECONDS_WARN", 0)
+	maxLagSecondsCrit = atoiEnvDefault("MAX_LAG_SECONDS_CRIT", 0)
+	minRespondingSecondaries = atoiEnvDefault("MIN_RESPONDING_SECONDARIES", 0)
+	consecutiveFailuresBeforeAlert = atoiEnvDefault("CONSECUTIVE_FAILURES_BEFORE_ALERT", 1)
+	alertsListen = os.Getenv("ALERTS_LISTEN")
+
+	notifiers = nil
+	if url := os.Getenv("SLACK_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, &SlackNotifier{webhookURL: url})
+	}
+	if routingKey := os.Getenv("PAGERDUTY_ROUTING_KEY"); routingKey != "" {
+		notifiers = append(notifiers, &PagerDutyNotifier{routingKey: routingKey})
+	}
+	if url := os.Getenv("ALERT_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, &GenericWebhookNotifier{url: url})
+	}
+}
+
+// Alert is a single threshold crossing (or recovery) for a monitored
+// domain/secondary pair, handed to every configured Notifier.
+type Alert struct {
+	Domain    string        `json:"domain"`
+	Secondary string        `json:"secondary"`
+	Severity  AlertSeverity `json:"severity"`
+	Message   string        `json:"message"`
+}
+
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// MultiNotifier fans an alert out to every configured notifier, logging
+// (rather than failing the caller) on individual delivery errors.
+type MultiNotifier []Notifier
+
+func (m MultiNotifier) Notify(alert Alert) error {
+	for _, n := range m {
+		if err := n.Notify(alert); err != nil {
+			logger.WithFields(logrus.Fields{"domain": alert.Domain, "secondary": alert.Secondary}).WithError(err).Error("failed to deliver alert")
+		}
+	}
+	return nil
+}
+
+type SlackNotifier struct {
+	webhookURL string
+}
+
+func (n *SlackNotifier) Notify(alert Alert) error {
+	payload, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("[%s] %s/%s: %s", alert.Severity, alert.Domain, alert.Secondary, alert.Message),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal slack payload")
+	}
+
+	return postJSON(n.webhookURL, payload)
+}
+
+type PagerDutyNotifier struct {
+	routingKey string
+}
+
+func (n *PagerDutyNotifier) Notify(alert Alert) error {
+	action := "trigger"
+	if alert.Severity == AlertOK {
+		action = "resolve"
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"routing_key":  n.routingKey,
+		"event_action": action,
+		"dedup_key":    fmt.Sprintf("dns-soa-monitor:%s:%s", alert.Domain, alert.Secondary),
+		"payload": map[string]string{
+			"summary":  alert.Message,
+			"severity": string(alert.Severity),
+			"source":   alert.Domain,
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal pagerduty payload")
+	}
+
+	return postJSON("https://events.pagerduty.com/v2/enqueue", payload)
+}
+
+type GenericWebhookNotifier struct {
+	url string
+}
+
+func (n *GenericWebhookNotifier) Notify(alert Alert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal webhook payload")
+	}
+
+	return postJSON(n.url, payload)
+}
+
+func postJSON(url string, payload []byte) error {
+	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrapf(err, "failed to post to %v", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("post to %v returned status %v", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// evaluateSecondaryAlert runs a per-(domain,secondary) state machine: it
+// only notifies on a severity crossing, and a crossing only latches once
+// the *same* observed severity has been seen for consecutiveFailuresBeforeAlert
+// cycles in a row. This gate applies equally to upgrades, downgrades, and
+// full recovery, so e.g. lag oscillating across the crit/warn boundary
+// doesn't flap an alert every cycle.
+func evaluateSecondaryAlert(domainName, secondaryServer string, observed AlertSeverity, message string) {
+	key := alertKey{domain: domainName, secondary: secondaryServer}
+
+	alertStatesM.Lock()
+	state, ok := alertStates[key]
+	if !ok {
+		state = &alertState{severity: AlertOK}
+		alertStates[key] = state
+	}
+
+	if observed == state.pending {
+		state.pendingCount++
+	} else {
+		state.pending = observed
+		state.pendingCount = 1
+	}
+
+	if state.severity != observed && state.pendingCount >= consecutiveFailuresBeforeAlert {
+		previous := state.severity
+		state.severity = observed
+		alertStatesM.Unlock()
+
+		notifyMessage := message
+		if observed == AlertOK {
+			notifyMessage = fmt.Sprintf("recovered from %v: %v", previous, message)
+		}
+		notifiers.Notify(Alert{Domain: domainName, Secondary: secondaryServer, Severity: observed, Message: notifyMessage})
+		return
+	}
+
+	alertStatesM.Unlock()
+}
+
+// evaluateDomainAlerts is called at the end of each runDomainMonitor
+// iteration once some primary has responded (earlier iterations skip it) to
+// check the lag and responsiveness thresholds for every secondary, plus the
+// domain-wide MIN_RESPONDING_SECONDARIES threshold.
+func evaluateDomainAlerts(domainName string, secondaryServers []string, serials map[string]uint32, maxSerial uint32) {
+	respondingCount := 0
+
+	for _, secondaryServer := range secondaryServers {
+		serial, responded := serials[secondaryServer]
+
+		severity := AlertOK
+		var message string
+
+		if !responded {
+			severity = AlertCrit
+			message = fmt.Sprintf("%v did not respond to the soa query", secondaryServer)
+		} else {
+			respondingCount++
+			lagSeconds := int64(maxSerial) - int64(serial)
+
+			if maxLagSecondsCrit > 0 && lagSeconds >= int64(maxLagSecondsCrit) {
+				severity = AlertCrit
+			} else if maxLagSecondsWarn > 0 && lagSeconds >= int64(maxLagSecondsWarn) {
+				severity = AlertWarn
+			}
+			message = fmt.Sprintf("%v is %v seconds behind", secondaryServer, lagSeconds)
+		}
+
+		evaluateSecondaryAlert(domainName, secondaryServer, severity, message)
+	}
+
+	if minRespondingSecondaries > 0 {
+		severity := AlertOK
+		if respondingCount < minRespondingSecondaries {
+			severity = AlertCrit
+		}
+
+		evaluateSecondaryAlert(domainName, "*", severity, fmt.Sprintf("%v/%v secondaries responding", respondingCount, len(secondaryServers)))
+	}
+}
+
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	alertStatesM.Lock()
+	snapshot := make([]Alert, 0, len(alertStates))
+	for key, state := range alertStates {
+		snapshot = append(snapshot, Alert{Domain: key.domain, Secondary: key.secondary, Severity: state.severity})
+	}
+	alertStatesM.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func startAlertsServer(listen string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alerts", handleAlerts)
+
+	server := &http.Server{Addr: listen, Handler: mux}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
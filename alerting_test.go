@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+// resetAlertState clears global alerting state between test cases, since
+// evaluateSecondaryAlert and notifiers are package-level.
+func resetAlertState(threshold int) {
+	alertStatesM.Lock()
+	alertStates = make(map[alertKey]*alertState)
+	alertStatesM.Unlock()
+
+	consecutiveFailuresBeforeAlert = threshold
+	notifiers = nil
+}
+
+type recordingNotifier struct {
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Notify(alert Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func TestEvaluateSecondaryAlertRequiresConsecutiveCyclesToLatch(t *testing.T) {
+	resetAlertState(3)
+	rec := &recordingNotifier{}
+	notifiers = MultiNotifier{rec}
+
+	evaluateSecondaryAlert("example.com", "ns2", AlertCrit, "lagging")
+	evaluateSecondaryAlert("example.com", "ns2", AlertCrit, "lagging")
+	if len(rec.alerts) != 0 {
+		t.Fatalf("alert fired after %v cycles, want no alert before the threshold", len(rec.alerts)+1)
+	}
+
+	evaluateSecondaryAlert("example.com", "ns2", AlertCrit, "lagging")
+	if len(rec.alerts) != 1 {
+		t.Fatalf("got %v alerts after reaching threshold, want 1", len(rec.alerts))
+	}
+	if rec.alerts[0].Severity != AlertCrit {
+		t.Errorf("alert severity = %v, want %v", rec.alerts[0].Severity, AlertCrit)
+	}
+}
+
+func TestEvaluateSecondaryAlertDowngradeIsGated(t *testing.T) {
+	resetAlertState(2)
+	rec := &recordingNotifier{}
+	notifiers = MultiNotifier{rec}
+
+	// Latch crit.
+	evaluateSecondaryAlert("example.com", "ns2", AlertCrit, "bad")
+	evaluateSecondaryAlert("example.com", "ns2", AlertCrit, "bad")
+	if len(rec.alerts) != 1 {
+		t.Fatalf("got %v alerts after latching crit, want 1", len(rec.alerts))
+	}
+
+	// A single warn observation should not immediately downgrade.
+	evaluateSecondaryAlert("example.com", "ns2", AlertWarn, "still bad")
+	if len(rec.alerts) != 1 {
+		t.Fatalf("downgrade fired after a single cycle, want it gated like any other transition")
+	}
+
+	// Flapping back to crit resets the pending streak; still no downgrade.
+	evaluateSecondaryAlert("example.com", "ns2", AlertCrit, "bad again")
+	if len(rec.alerts) != 1 {
+		t.Fatalf("got %v alerts after a flap, want the crit/warn flap to produce no new alert", len(rec.alerts))
+	}
+
+	// Two consecutive warn observations reach the threshold and latch the downgrade.
+	evaluateSecondaryAlert("example.com", "ns2", AlertWarn, "improving")
+	evaluateSecondaryAlert("example.com", "ns2", AlertWarn, "improving")
+	if len(rec.alerts) != 2 {
+		t.Fatalf("got %v alerts after a sustained downgrade, want 2", len(rec.alerts))
+	}
+	if rec.alerts[1].Severity != AlertWarn {
+		t.Errorf("second alert severity = %v, want %v", rec.alerts[1].Severity, AlertWarn)
+	}
+}
+
+func TestEvaluateSecondaryAlertRecovery(t *testing.T) {
+	resetAlertState(1)
+	rec := &recordingNotifier{}
+	notifiers = MultiNotifier{rec}
+
+	evaluateSecondaryAlert("example.com", "ns2", AlertCrit, "bad")
+	evaluateSecondaryAlert("example.com", "ns2", AlertOK, "recovered")
+
+	if len(rec.alerts) != 2 {
+		t.Fatalf("got %v alerts, want 2 (crit then recovery)", len(rec.alerts))
+	}
+	if rec.alerts[1].Severity != AlertOK {
+		t.Errorf("recovery alert severity = %v, want %v", rec.alerts[1].Severity, AlertOK)
+	}
+}
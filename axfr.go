@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	axfrEnabled  = false
+	axfrTsigKey  = ""
+	axfrInterval = 300
+)
+
+func normalizeRRsetHash(envelopes []*dns.Envelope) (string, int, error) {
+	lines := []string{}
+
+	for _, e := range envelopes {
+		if e.Error != nil {
+			return "", 0, errors.Wrapf(e.Error, "failed to receive axfr envelope")
+		}
+
+		for _, rr := range e.RR {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeSOA {
+				continue
+			}
+			lines = append(lines, rr.String())
+		}
+	}
+
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte("\n"))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), len(lines), nil
+}
+
+func transferZone(domainName, server string) (string, int, error) {
+	transport := parseServerTransport(server)
+	if transport.scheme == "https" {
+		return "", 0, errors.Errorf("axfr against %v is not supported: AXFR requires a plain DNS transport, not DoH", server)
+	}
+
+	m := new(dns.Msg)
+	m.SetAxfr(domainName + ".")
+
+	transfer := new(dns.Transfer)
+
+	if axfrTsigKey != "" {
+		name, secret, algo, err := parseTsigKey(axfrTsigKey)
+		if err != nil {
+			return "", 0, errors.Wrapf(err, "failed to parse AXFR_TSIG_KEY")
+		}
+		transfer.TsigSecret = map[string]string{name: secret}
+		m.SetTsig(name, algo, 300, time.Now().Unix())
+	}
+
+	envelopes, err := transfer.In(m, transport.addr)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to start axfr")
+	}
+
+	received := []*dns.Envelope{}
+	for e := range envelopes {
+		received = append(received, e)
+	}
+
+	return normalizeRRsetHash(received)
+}
+
+func parseTsigKey(key string) (name, secret string, algo string, err error) {
+	parts := splitTsigKey(key)
+	if len(parts) != 2 {
+		return "", "", "", errors.New("AXFR_TSIG_KEY must be in name:secret form")
+	}
+	return dns.Fqdn(parts[0]), parts[1], dns.HmacSHA256, nil
+}
+
+func splitTsigKey(key string) []string {
+	for i := 0; i < len(key); i++ {
+		if key[i] == ':' {
+			return []string{key[:i], key[i+1:]}
+		}
+	}
+	return []string{key}
+}
+
+func runZoneTransferMonitor(ctx context.Context, domainName string, targetServers []string) {
+	for {
+		hashes := make(map[string]string)
+
+		for _, server := range targetServers {
+			hash, rrsetCount, err := transferZone(domainName, server)
+			if err != nil {
+				processError(logrus.Fields{"domain": domainName, "secondary": server}, errors.Wrapf(err, "axfr against %v for %v", server, domainName))
+				continue
+			}
+
+			hashes[server] = hash
+
+			sink.RecordRRSetCount(domainName, server, rrsetCount)
+
+			if debug {
+				log.Printf("domain_name=%v server=%v zone_hash=%v rrset_count=%v", domainName, server, hash, rrsetCount)
+			}
+		}
+
+		mismatch := false
+		seen := ""
+		for _, hash := range hashes {
+			if seen == "" {
+				seen = hash
+				continue
+			}
+			if hash != seen {
+				mismatch = true
+			}
+		}
+
+		if mismatch {
+			processError(logrus.Fields{"domain": domainName}, errors.Errorf("zone hash mismatch detected for %v", domainName))
+		}
+
+		sink.RecordZoneHashMismatch(domainName, mismatch)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(axfrInterval) * time.Second):
+		}
+	}
+}
+
+func loadAxfrConfig() {
+	axfrEnabled = os.Getenv("AXFR_ENABLED") == "true"
+	axfrTsigKey = os.Getenv("AXFR_TSIG_KEY")
+
+	if os.Getenv("AXFR_INTERVAL") != "" {
+		v, err := strconv.Atoi(os.Getenv("AXFR_INTERVAL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		axfrInterval = v
+	}
+}
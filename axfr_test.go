@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSplitTsigKey(t *testing.T) {
+	cases := []struct {
+		key  string
+		want []string
+	}{
+		{"example.:secretvalue", []string{"example.", "secretvalue"}},
+		{"name:with:colons", []string{"name", "with:colons"}},
+		{"nocolon", []string{"nocolon"}},
+	}
+
+	for _, c := range cases {
+		got := splitTsigKey(c.key)
+		if len(got) != len(c.want) {
+			t.Errorf("splitTsigKey(%q) = %v, want %v", c.key, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("splitTsigKey(%q) = %v, want %v", c.key, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func mustA(t *testing.T, rr string) dns.RR {
+	t.Helper()
+	r, err := dns.NewRR(rr)
+	if err != nil {
+		t.Fatalf("failed to build test RR %q: %v", rr, err)
+	}
+	return r
+}
+
+func TestNormalizeRRsetHashOrderIndependent(t *testing.T) {
+	soa := mustA(t, "example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 2 3 4 5")
+	a1 := mustA(t, "example.com. 300 IN A 192.0.2.1")
+	a2 := mustA(t, "example.com. 300 IN A 192.0.2.2")
+
+	hashA, countA, err := normalizeRRsetHash([]*dns.Envelope{{RR: []dns.RR{soa, a1, a2}}})
+	if err != nil {
+		t.Fatalf("normalizeRRsetHash returned error: %v", err)
+	}
+
+	hashB, countB, err := normalizeRRsetHash([]*dns.Envelope{{RR: []dns.RR{soa, a2, a1}}})
+	if err != nil {
+		t.Fatalf("normalizeRRsetHash returned error: %v", err)
+	}
+
+	if hashA != hashB {
+		t.Errorf("normalizeRRsetHash hash depends on record order: %v != %v", hashA, hashB)
+	}
+	if countA != 2 || countB != 2 {
+		t.Errorf("normalizeRRsetHash rrsetCount = %v, %v, want 2 (SOA excluded)", countA, countB)
+	}
+}
+
+func TestNormalizeRRsetHashPropagatesEnvelopeError(t *testing.T) {
+	wantErr := errors.New("transfer failed")
+
+	_, _, err := normalizeRRsetHash([]*dns.Envelope{{Error: wantErr}})
+	if err == nil {
+		t.Fatal("normalizeRRsetHash did not return an error for a failed envelope")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	raven "github.com/getsentry/raven-go"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DomainConfig is a single monitored zone and the primary/secondary
+// servers authoritative for it. Different zones are frequently delegated
+// to different server sets, so each domain carries its own.
+type DomainConfig struct {
+	Name             string   `json:"name" yaml:"name"`
+	PrimaryServers   []string `json:"primary_servers" yaml:"primary_servers"`
+	SecondaryServers []string `json:"secondary_servers" yaml:"secondary_servers"`
+}
+
+// Config is the CONFIG_FILE representation of the monitored domains and
+// POLL_INTERVAL env var, reloaded on SIGHUP.
+type Config struct {
+	Domains      []DomainConfig `json:"domains" yaml:"domains"`
+	PollInterval int            `json:"poll_interval" yaml:"poll_interval"`
+}
+
+func loadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %v", path)
+	}
+
+	cfg := new(Config)
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse config file %v as json", path)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse config file %v as yaml", path)
+		}
+	}
+
+	return cfg, nil
+}
+
+// domainMonitorManager reconciles the running per-domain SOA, AXFR, and
+// NOTIFY-propagation monitor goroutines against the latest Config, starting
+// monitors for newly added domains and cancelling monitors for removed
+// domains via context.Context. Each monitor kind is tracked independently
+// so a reload can't leave AXFR or NOTIFY goroutines running for a domain
+// that has since been removed, or skip starting them for one that's new.
+type domainMonitorManager struct {
+	mu           sync.Mutex
+	soaCancel    map[string]context.CancelFunc
+	axfrCancel   map[string]context.CancelFunc
+	notifyCancel map[string]context.CancelFunc
+}
+
+func newDomainMonitorManager() *domainMonitorManager {
+	return &domainMonitorManager{
+		soaCancel:    make(map[string]context.CancelFunc),
+		axfrCancel:   make(map[string]context.CancelFunc),
+		notifyCancel: make(map[string]context.CancelFunc),
+	}
+}
+
+func (mgr *domainMonitorManager) reconcile(cfg *Config) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	wanted := make(map[string]bool)
+	for _, domain := range cfg.Domains {
+		wanted[domain.Name] = true
+
+		if _, running := mgr.soaCancel[domain.Name]; !running {
+			ctx, cancel := context.WithCancel(context.Background())
+			mgr.soaCancel[domain.Name] = cancel
+
+			go func(domain DomainConfig) {
+				raven.CapturePanicAndWait(func() {
+					runDomainMonitor(ctx, domain.Name, domain.PrimaryServers, domain.SecondaryServers)
+				}, nil)
+			}(domain)
+
+			logger.WithField("domain", domain.Name).Info("started monitor")
+		}
+
+		if axfrEnabled {
+			if _, running := mgr.axfrCancel[domain.Name]; !running {
+				ctx, cancel := context.WithCancel(context.Background())
+				mgr.axfrCancel[domain.Name] = cancel
+
+				targetServers := []string{}
+				targetServers = append(targetServers, domain.PrimaryServers...)
+				targetServers = append(targetServers, domain.SecondaryServers...)
+
+				go func(domain DomainConfig) {
+					raven.CapturePanicAndWait(func() {
+						runZoneTransferMonitor(ctx, domain.Name, targetServers)
+					}, nil)
+				}(domain)
+
+				logger.WithField("domain", domain.Name).Info("started axfr monitor")
+			}
+		}
+
+		if notifyListen != "" {
+			if _, running := mgr.notifyCancel[domain.Name]; !running {
+				ctx, cancel := context.WithCancel(context.Background())
+				mgr.notifyCancel[domain.Name] = cancel
+
+				triggers := registerNotifyTrigger(domain.Name)
+
+				go func(domain DomainConfig) {
+					raven.CapturePanicAndWait(func() {
+						runPropagationMonitor(ctx, domain.Name, domain.PrimaryServers, domain.SecondaryServers, triggers)
+					}, nil)
+				}(domain)
+
+				logger.WithField("domain", domain.Name).Info("started propagation monitor")
+			}
+		}
+	}
+
+	for domainName, cancel := range mgr.soaCancel {
+		if !wanted[domainName] {
+			cancel()
+			delete(mgr.soaCancel, domainName)
+			logger.WithField("domain", domainName).Info("stopped monitor")
+		}
+	}
+
+	for domainName, cancel := range mgr.axfrCancel {
+		if !wanted[domainName] {
+			cancel()
+			delete(mgr.axfrCancel, domainName)
+			logger.WithField("domain", domainName).Info("stopped axfr monitor")
+		}
+	}
+
+	for domainName, cancel := range mgr.notifyCancel {
+		if !wanted[domainName] {
+			cancel()
+			delete(mgr.notifyCancel, domainName)
+			logger.WithField("domain", domainName).Info("stopped propagation monitor")
+		}
+	}
+}
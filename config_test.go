@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	contents := `{
+		"poll_interval": 30,
+		"domains": [
+			{"name": "example.com", "primary_servers": ["127.0.0.1:1"], "secondary_servers": ["127.0.0.1:2"]}
+		]
+	}`
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("loadConfigFile returned error: %v", err)
+	}
+
+	if cfg.PollInterval != 30 {
+		t.Errorf("cfg.PollInterval = %v, want 30", cfg.PollInterval)
+	}
+	if len(cfg.Domains) != 1 || cfg.Domains[0].Name != "example.com" {
+		t.Errorf("cfg.Domains = %+v, want a single example.com entry", cfg.Domains)
+	}
+}
+
+func TestLoadConfigFileYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	contents := `
+poll_interval: 45
+domains:
+  - name: example.com
+    primary_servers: ["127.0.0.1:1"]
+    secondary_servers: ["127.0.0.1:2"]
+`
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("loadConfigFile returned error: %v", err)
+	}
+
+	if cfg.PollInterval != 45 {
+		t.Errorf("cfg.PollInterval = %v, want 45", cfg.PollInterval)
+	}
+	if len(cfg.Domains) != 1 || cfg.Domains[0].Name != "example.com" {
+		t.Errorf("cfg.Domains = %+v, want a single example.com entry", cfg.Domains)
+	}
+}
+
+func TestLoadConfigFileMissing(t *testing.T) {
+	if _, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("loadConfigFile did not return an error for a missing file")
+	}
+}
+
+func TestDomainMonitorManagerReconcile(t *testing.T) {
+	// Keep this test to the SOA monitor kind only; AXFR/NOTIFY are covered
+	// by the axfrEnabled/notifyListen globals which default to off.
+	origAxfrEnabled, origNotifyListen := axfrEnabled, notifyListen
+	axfrEnabled, notifyListen = false, ""
+	defer func() { axfrEnabled, notifyListen = origAxfrEnabled, origNotifyListen }()
+
+	mgr := newDomainMonitorManager()
+
+	mgr.reconcile(&Config{Domains: []DomainConfig{
+		{Name: "example.com", PrimaryServers: []string{"127.0.0.1:1"}, SecondaryServers: []string{"127.0.0.1:2"}},
+	}})
+
+	if len(mgr.soaCancel) != 1 {
+		t.Fatalf("soaCancel has %v entries after reconcile, want 1", len(mgr.soaCancel))
+	}
+	if _, ok := mgr.soaCancel["example.com"]; !ok {
+		t.Fatalf("soaCancel missing entry for example.com: %+v", mgr.soaCancel)
+	}
+	if len(mgr.axfrCancel) != 0 || len(mgr.notifyCancel) != 0 {
+		t.Fatalf("axfr/notify monitors started despite axfrEnabled=false and notifyListen=\"\"")
+	}
+
+	// Reconciling against an empty domain list should cancel and remove it.
+	mgr.reconcile(&Config{})
+
+	if len(mgr.soaCancel) != 0 {
+		t.Fatalf("soaCancel has %v entries after removing the domain, want 0", len(mgr.soaCancel))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	autoDiscoverNS      = false
+	nsDiscoveryInterval = 3600
+)
+
+func loadDiscoveryConfig() {
+	autoDiscoverNS = os.Getenv("AUTO_DISCOVER_NS") == "true"
+
+	if os.Getenv("NS_DISCOVERY_INTERVAL") != "" {
+		v, err := strconv.Atoi(os.Getenv("NS_DISCOVERY_INTERVAL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		nsDiscoveryInterval = v
+	}
+}
+
+func resolveNSAddresses(nsName string) ([]string, error) {
+	addrs, err := net.LookupHost(strings.TrimSuffix(nsName, "."))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %v", nsName)
+	}
+	return addrs, nil
+}
+
+// discoverServers queries the zone's NS RRset via the system resolver,
+// resolves each NS name to A/AAAA addresses, and splits the result into
+// primaries (the server named in the SOA MNAME) and secondaries (everyone
+// else).
+func discoverServers(domainName string) (primaryServers, secondaryServers []string, err error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		return nil, nil, errors.Wrapf(err, "failed to read system resolver config")
+	}
+	bootstrap := net.JoinHostPort(conf.Servers[0], conf.Port)
+
+	soaQuery := new(dns.Msg)
+	soaQuery.SetQuestion(dns.Fqdn(domainName), dns.TypeSOA)
+	soaResp, err := dns.Exchange(soaQuery, bootstrap)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to query soa for %v via bootstrap resolver", domainName)
+	}
+
+	mname := ""
+	for _, rr := range soaResp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			mname = soa.Ns
+			break
+		}
+	}
+	if mname == "" {
+		return nil, nil, errors.Errorf("no soa mname found for %v", domainName)
+	}
+
+	nsQuery := new(dns.Msg)
+	nsQuery.SetQuestion(dns.Fqdn(domainName), dns.TypeNS)
+	nsResp, err := dns.Exchange(nsQuery, bootstrap)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to query ns for %v via bootstrap resolver", domainName)
+	}
+
+	for _, rr := range nsResp.Answer {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+
+		addrs, err := resolveNSAddresses(ns.Ns)
+		if err != nil {
+			processError(logrus.Fields{"domain": domainName, "ns": ns.Ns}, err)
+			continue
+		}
+
+		if strings.EqualFold(ns.Ns, mname) {
+			primaryServers = append(primaryServers, addrs...)
+		} else {
+			secondaryServers = append(secondaryServers, addrs...)
+		}
+	}
+
+	if len(primaryServers) == 0 {
+		return nil, nil, errors.Errorf("could not resolve primary (mname %v) for %v", mname, domainName)
+	}
+
+	return primaryServers, secondaryServers, nil
+}
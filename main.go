@@ -1,13 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,64 +17,68 @@ import (
 	"github.com/paulbellamy/ratecounter"
 	"github.com/pkg/errors"
 	librato "github.com/rcrowley/go-librato"
+	"github.com/sirupsen/logrus"
 )
 
 var (
-	pollInterval     = 60
+	pollInterval     atomic.Int64
 	m                librato.Metrics
-	debug            = false
-	errorRateCounter = ratecounter.NewRateCounter(60 * time.Second)
+	sink             MetricsSink = MultiSink{}
+	debug                        = false
+	errorRateCounter             = ratecounter.NewRateCounter(60 * time.Second)
 )
 
-func runErrorCountReporter() {
-	if m == nil && !debug {
-		return
-	}
+func init() {
+	pollInterval.Store(60)
+}
 
+func runErrorCountReporter() {
 	for {
 		errorRate := errorRateCounter.Rate() / 60
 
-		if m != nil {
-			c := m.GetCounter(fmt.Sprintf("travis.dns-soa-monitor.error_rate"))
-			c <- int64(errorRate)
-		}
+		sink.RecordErrorRate(int64(errorRate))
 
-		if debug {
-			log.Printf("error_rate=%v", errorRate)
-		}
+		logger.WithField("error_rate", errorRate).Debug("error rate")
 
-		time.Sleep(time.Duration(pollInterval) * time.Second)
+		time.Sleep(time.Duration(pollInterval.Load()) * time.Second)
 	}
 }
 
-func getSerial(domainName, server string) (uint32, error) {
+func getSerial(domainName, server string) (uint32, time.Duration, error) {
 	m := new(dns.Msg)
 	m.SetQuestion(domainName+".", dns.TypeSOA)
 
-	r, err := dns.Exchange(m, server+":53")
+	start := time.Now()
+	r, err := exchange(m, server)
+	duration := time.Since(start)
 	if err != nil {
-		return 0, errors.Wrapf(err, "failed to exchange")
+		return 0, duration, errors.Wrapf(err, "failed to exchange")
 	}
 	if r == nil || r.Rcode != dns.RcodeSuccess {
-		return 0, errors.Wrapf(err, "failed to get an valid answer")
+		return 0, duration, errors.Wrapf(err, "failed to get an valid answer")
 	}
 
 	if len(r.Answer) == 0 {
-		return 0, errors.New("no records returned for soa query")
+		return 0, duration, errors.New("no records returned for soa query")
 	}
 
 	if len(r.Answer) > 1 {
-		return 0, errors.New("too many records returned for soa query")
+		return 0, duration, errors.New("too many records returned for soa query")
 	}
 
 	if t, ok := r.Answer[0].(*dns.SOA); ok {
-		return t.Serial, nil
+		return t.Serial, duration, nil
 	}
 
-	return 0, errors.New("no soa record returned")
+	return 0, duration, errors.New("no soa record returned")
+}
+
+type serialError struct {
+	server string
+	err    error
 }
 
-func getSerials(domainName string, targetServers []string, errs chan<- error) map[string]uint32 {
+func getSerials(domainName string, targetServers []string, errs chan<- serialError) map[string]uint32 {
 	serials := make(map[string]uint32)
 	var mutex sync.Mutex
 
@@ -82,11 +87,13 @@ func getSerials(domainName string, targetServers []string, errs chan<- error) ma
 		wg.Add(1)
 		go func(server string) {
 			defer wg.Done()
-			serial, err := getSerial(domainName, server)
+			serial, duration, err := getSerial(domainName, server)
+			sink.RecordQueryDuration(domainName, server, duration)
 			if err != nil {
-				errs <- err
+				errs <- serialError{server: server, err: err}
 				return
 			}
+			sink.RecordSerial(domainName, server, serial)
 			mutex.Lock()
 			serials[server] = serial
 			mutex.Unlock()
@@ -103,28 +110,54 @@ func metricsify(s string) string {
 	return strings.Replace(s, ".", "_", -1)
 }
 
-func processError(err error) {
-	log.Printf("error: %v", err)
+func processError(fields logrus.Fields, err error) {
+	logger.WithFields(fields).WithError(err).Error("error")
 	errorRateCounter.Incr(1)
 	raven.CaptureErrorAndWait(err, nil)
 }
 
-func runDomainMonitor(domainName string, primaryServers, secondaryServers []string) {
+// waitNextPoll blocks until ctx is cancelled or the next poll is due,
+// reporting which happened so callers can bail out of their loop promptly
+// instead of spinning when a domain is perpetually unhealthy.
+func waitNextPoll(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Duration(pollInterval.Load()) * time.Second):
+		return true
+	}
+}
+
+func runDomainMonitor(ctx context.Context, domainName string, primaryServers, secondaryServers []string) {
 	// remember max serial between polls
 	maxSerial := uint32(0)
 	maxSerialPrimaryServer := ""
+	lastDiscovery := time.Time{}
 
 	for {
-		log.Printf("polling %s", domainName)
+		if autoDiscoverNS && time.Since(lastDiscovery) > time.Duration(nsDiscoveryInterval)*time.Second {
+			discoveredPrimary, discoveredSecondary, err := discoverServers(domainName)
+			if err != nil {
+				processError(logrus.Fields{"domain": domainName}, errors.Wrapf(err, "ns auto-discovery failed for %v", domainName))
+			} else {
+				primaryServers = discoveredPrimary
+				secondaryServers = discoveredSecondary
+				lastDiscovery = time.Now()
+				logger.WithFields(logrus.Fields{"domain": domainName, "primary": primaryServers, "secondary": secondaryServers}).Info("refreshed auto-discovered servers")
+			}
+		}
+
+		logger.WithField("domain", domainName).Info("polling")
 
 		targetServers := []string{}
 		targetServers = append(targetServers, primaryServers...)
 		targetServers = append(targetServers, secondaryServers...)
 
-		errs := make(chan error)
+		errs := make(chan serialError)
 		go func() {
-			for err := range errs {
-				processError(err)
+			for se := range errs {
+				processError(logrus.Fields{"domain": domainName, "secondary": se.server}, se.err)
+				sink.RecordError(domainName, se.server, "query_error")
 			}
 		}()
 
@@ -144,7 +177,10 @@ func runDomainMonitor(domainName string, primaryServers, secondaryServers []stri
 
 		if maxSerialPrimaryServer == "" {
 			err := errors.Errorf("no primary server responded for %v", domainName)
-			processError(err)
+			processError(logrus.Fields{"domain": domainName}, err)
+			if !waitNextPoll(ctx) {
+				return
+			}
 			continue
 		}
 
@@ -158,61 +194,83 @@ func runDomainMonitor(domainName string, primaryServers, secondaryServers []stri
 
 			lagSeconds := int64(maxSerial) - int64(secondarySerial)
 
-			if debug {
-				log.Printf("domain_name=%v primary_server=%v primary_serial=%v secondary_server=%v secondary_serial=%v lag_seconds=%v",
-					domainName, maxSerialPrimaryServer, maxSerial, secondaryServer, secondarySerial, lagSeconds)
-			}
+			logger.WithFields(logrus.Fields{
+				"domain":      domainName,
+				"primary":     maxSerialPrimaryServer,
+				"secondary":   secondaryServer,
+				"serial":      maxSerial,
+				"lag_seconds": lagSeconds,
+			}).Debug("lag computed")
 
-			if m != nil {
-				g := m.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.primary.%s.secondary.%s.lag_seconds", metricsify(domainName), metricsify(maxSerialPrimaryServer), metricsify(secondaryServer)))
-				g <- int64(lagSeconds)
-			}
+			sink.RecordLagSeconds(domainName, maxSerialPrimaryServer, secondaryServer, lagSeconds)
 
 			if lagSeconds > maxLagSeconds {
 				maxLagSeconds = lagSeconds
 			}
 		}
 
-		if debug {
-			log.Printf("domain_name=%v max_lag_seconds=%v", domainName, maxLagSeconds)
-		}
+		logger.WithFields(logrus.Fields{"domain": domainName, "lag_seconds": maxLagSeconds}).Debug("max lag computed")
 
-		if m != nil {
-			g := m.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.max_lag_seconds", metricsify(domainName)))
-			g <- int64(maxLagSeconds)
-		}
+		sink.RecordMaxLagSeconds(domainName, maxLagSeconds)
 
-		time.Sleep(time.Duration(pollInterval) * time.Second)
+		evaluateDomainAlerts(domainName, secondaryServers, serials, maxSerial)
+
+		if !waitNextPoll(ctx) {
+			return
+		}
 	}
 }
 
 func main() {
-	domainNames := strings.Split(os.Getenv("DOMAIN_NAMES"), ",")
-	if os.Getenv("DOMAIN_NAMES") == "" {
-		log.Fatal("please provide the DOMAIN_NAMES env variable")
-	}
+	configFile := os.Getenv("CONFIG_FILE")
 
-	primaryServers := strings.Split(os.Getenv("PRIMARY_SERVERS"), ",")
-	if os.Getenv("PRIMARY_SERVERS") == "" {
-		log.Fatal("please provide the PRIMARY_SERVERS env variable")
-	}
+	var domains []DomainConfig
+
+	if configFile == "" {
+		if os.Getenv("DOMAIN_NAMES") == "" {
+			log.Fatal("please provide the DOMAIN_NAMES env variable")
+		}
+
+		autoDiscover := os.Getenv("AUTO_DISCOVER_NS") == "true"
+
+		var primaryServers, secondaryServers []string
+		if autoDiscover {
+			log.Print("AUTO_DISCOVER_NS enabled, primary/secondary servers will be discovered on first poll")
+		} else {
+			if os.Getenv("PRIMARY_SERVERS") == "" {
+				log.Fatal("please provide the PRIMARY_SERVERS env variable")
+			}
+			if os.Getenv("SECONDARY_SERVERS") == "" {
+				log.Fatal("please provide the SECONDARY_SERVERS env variable")
+			}
+
+			primaryServers = strings.Split(os.Getenv("PRIMARY_SERVERS"), ",")
+			secondaryServers = strings.Split(os.Getenv("SECONDARY_SERVERS"), ",")
+		}
 
-	secondaryServers := strings.Split(os.Getenv("SECONDARY_SERVERS"), ",")
-	if os.Getenv("SECONDARY_SERVERS") == "" {
-		log.Fatal("please provide the SECONDARY_SERVERS env variable")
+		for _, domainName := range strings.Split(os.Getenv("DOMAIN_NAMES"), ",") {
+			domains = append(domains, DomainConfig{
+				Name:             domainName,
+				PrimaryServers:   primaryServers,
+				SecondaryServers: secondaryServers,
+			})
+		}
 	}
 
 	var err error
 	if os.Getenv("POLL_INTERVAL") != "" {
-		pollInterval, err = strconv.Atoi(os.Getenv("POLL_INTERVAL"))
+		v, err := strconv.Atoi(os.Getenv("POLL_INTERVAL"))
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("running with POLL_INTERVAL of %v", pollInterval)
+		pollInterval.Store(int64(v))
+		log.Printf("running with POLL_INTERVAL of %v", pollInterval.Load())
 	} else {
-		log.Printf("defaulting POLL_INTERVAL to %v", pollInterval)
+		log.Printf("defaulting POLL_INTERVAL to %v", pollInterval.Load())
 	}
 
+	sinks := MultiSink{}
+
 	if os.Getenv("LIBRATO_USER") != "" && os.Getenv("LIBRATO_TOKEN") != "" {
 		source := os.Getenv("LIBRATO_SOURCE")
 		if source == "" {
@@ -232,10 +290,20 @@ func main() {
 		)
 		defer m.Wait()
 		defer m.Close()
+
+		sinks = append(sinks, NewLibratoSink(m))
 	} else {
 		log.Print("no librato config provided, to enable librato, please provide LIBRATO_USER and LIBRATO_TOKEN")
 	}
 
+	if os.Getenv("METRICS_LISTEN") != "" {
+		sinks = append(sinks, NewPrometheusSink())
+		startMetricsServer(os.Getenv("METRICS_LISTEN"))
+		log.Printf("serving prometheus metrics on %v/metrics", os.Getenv("METRICS_LISTEN"))
+	}
+
+	sink = sinks
+
 	if os.Getenv("SENRTY_DSN") != "" {
 		err := raven.SetDSN(os.Getenv("SENRTY_DSN"))
 		if err != nil {
@@ -249,15 +317,97 @@ func main() {
 	}
 
 	debug = os.Getenv("DEBUG") == "true"
+	if debug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	loadAxfrConfig()
+	loadNotifyConfig()
+	loadDiscoveryConfig()
+	loadAlertingConfig()
+
+	if alertsListen != "" {
+		startAlertsServer(alertsListen)
+		log.Printf("serving alert state on %v/alerts", alertsListen)
+	}
 
 	go raven.CapturePanicAndWait(runErrorCountReporter, nil)
 
-	for _, domainName := range domainNames {
-		go func(domainName string, primaryServers, secondaryServers []string) {
-			raven.CapturePanicAndWait(func() {
-				runDomainMonitor(domainName, primaryServers, secondaryServers)
-			}, nil)
-		}(domainName, primaryServers, secondaryServers)
+	manager := newDomainMonitorManager()
+
+	if configFile != "" {
+		cfg, err := loadConfigFile(configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if cfg.PollInterval != 0 {
+			pollInterval.Store(int64(cfg.PollInterval))
+		}
+		domains = cfg.Domains
+
+		manager.reconcile(cfg)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				logger.WithField("config_file", configFile).Info("reloading config")
+
+				cfg, err := loadConfigFile(configFile)
+				if err != nil {
+					logger.WithField("config_file", configFile).WithError(err).Error("failed to reload config")
+					continue
+				}
+
+				if cfg.PollInterval != 0 {
+					pollInterval.Store(int64(cfg.PollInterval))
+				}
+
+				manager.reconcile(cfg)
+			}
+		}()
+	} else {
+		// No CONFIG_FILE means no SIGHUP reload, so the domain set is fixed
+		// at boot: start every monitor kind once here rather than going
+		// through manager.reconcile, which exists to track additions and
+		// removals across reloads.
+		for _, domain := range domains {
+			go func(domain DomainConfig) {
+				raven.CapturePanicAndWait(func() {
+					runDomainMonitor(context.Background(), domain.Name, domain.PrimaryServers, domain.SecondaryServers)
+				}, nil)
+			}(domain)
+
+			if axfrEnabled {
+				go func(domain DomainConfig) {
+					targetServers := []string{}
+					targetServers = append(targetServers, domain.PrimaryServers...)
+					targetServers = append(targetServers, domain.SecondaryServers...)
+
+					raven.CapturePanicAndWait(func() {
+						runZoneTransferMonitor(context.Background(), domain.Name, targetServers)
+					}, nil)
+				}(domain)
+			}
+
+			if notifyListen != "" {
+				triggers := registerNotifyTrigger(domain.Name)
+
+				go func(domain DomainConfig) {
+					raven.CapturePanicAndWait(func() {
+						runPropagationMonitor(context.Background(), domain.Name, domain.PrimaryServers, domain.SecondaryServers, triggers)
+					}, nil)
+				}(domain)
+			}
+		}
+	}
+
+	if notifyListen != "" {
+		go raven.CapturePanicAndWait(func() {
+			runNotifyListener(notifyListen)
+		}, nil)
+		log.Printf("listening for NOTIFY messages on %v", notifyListen)
 	}
 
 	exitSignal := make(chan os.Signal)
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	librato "github.com/rcrowley/go-librato"
+)
+
+// MetricsSink abstracts the metrics backend(s) that runDomainMonitor and
+// runErrorCountReporter report through, so the same call site can feed
+// Librato, Prometheus, both, or neither.
+type MetricsSink interface {
+	RecordSerial(domainName, server string, serial uint32)
+	RecordLagSeconds(domainName, primaryServer, secondaryServer string, lagSeconds int64)
+	RecordMaxLagSeconds(domainName string, lagSeconds int64)
+	RecordQueryDuration(domainName, server string, d time.Duration)
+	RecordError(domainName, server, kind string)
+	RecordErrorRate(errorRate int64)
+	RecordPropagationSeconds(domainName string, seconds float64)
+	RecordRRSetCount(domainName, server string, rrsetCount int)
+	RecordZoneHashMismatch(domainName string, mismatch bool)
+}
+
+// LibratoSink reports metrics through the existing go-librato client using
+// the dotted travis.dns-soa-monitor.* naming scheme.
+type LibratoSink struct {
+	metrics librato.Metrics
+}
+
+func NewLibratoSink(metrics librato.Metrics) *LibratoSink {
+	return &LibratoSink{metrics: metrics}
+}
+
+func (s *LibratoSink) RecordSerial(domainName, server string, serial uint32) {
+	g := s.metrics.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.server.%s.serial", metricsify(domainName), metricsify(server)))
+	g <- int64(serial)
+}
+
+func (s *LibratoSink) RecordLagSeconds(domainName, primaryServer, secondaryServer string, lagSeconds int64) {
+	g := s.metrics.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.primary.%s.secondary.%s.lag_seconds", metricsify(domainName), metricsify(primaryServer), metricsify(secondaryServer)))
+	g <- lagSeconds
+}
+
+func (s *LibratoSink) RecordMaxLagSeconds(domainName string, lagSeconds int64) {
+	g := s.metrics.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.max_lag_seconds", metricsify(domainName)))
+	g <- lagSeconds
+}
+
+func (s *LibratoSink) RecordQueryDuration(domainName, server string, d time.Duration) {
+	g := s.metrics.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.server.%s.query_duration_ms", metricsify(domainName), metricsify(server)))
+	g <- d.Milliseconds()
+}
+
+func (s *LibratoSink) RecordError(domainName, server, kind string) {
+	c := s.metrics.GetCounter(fmt.Sprintf("travis.dns-soa-monitor.%s.server.%s.errors.%s", metricsify(domainName), metricsify(server), metricsify(kind)))
+	c <- 1
+}
+
+func (s *LibratoSink) RecordErrorRate(errorRate int64) {
+	c := s.metrics.GetCounter(fmt.Sprintf("travis.dns-soa-monitor.error_rate"))
+	c <- errorRate
+}
+
+func (s *LibratoSink) RecordPropagationSeconds(domainName string, seconds float64) {
+	g := s.metrics.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.propagation_seconds", metricsify(domainName)))
+	g <- int64(seconds)
+}
+
+func (s *LibratoSink) RecordRRSetCount(domainName, server string, rrsetCount int) {
+	g := s.metrics.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.server.%s.rrset_count", metricsify(domainName), metricsify(server)))
+	g <- int64(rrsetCount)
+}
+
+func (s *LibratoSink) RecordZoneHashMismatch(domainName string, mismatch bool) {
+	g := s.metrics.GetGauge(fmt.Sprintf("travis.dns-soa-monitor.%s.zone_hash_mismatch", metricsify(domainName)))
+	if mismatch {
+		g <- 1
+	} else {
+		g <- 0
+	}
+}
+
+// PrometheusSink reports metrics through registered prometheus vectors,
+// served by an http.Server exposing promhttp.Handler().
+type PrometheusSink struct {
+	lagSeconds       *prometheus.GaugeVec
+	serial           *prometheus.GaugeVec
+	maxLagSeconds    *prometheus.GaugeVec
+	queryDuration    *prometheus.HistogramVec
+	errorsTotal      *prometheus.CounterVec
+	errorRate        prometheus.Gauge
+	propagation      *prometheus.GaugeVec
+	rrsetCount       *prometheus.GaugeVec
+	zoneHashMismatch *prometheus.GaugeVec
+}
+
+func NewPrometheusSink() *PrometheusSink {
+	s := &PrometheusSink{
+		lagSeconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_soa_lag_seconds",
+			Help: "Serial lag in seconds between a domain's primary and secondary servers.",
+		}, []string{"domain", "primary", "secondary"}),
+		serial: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_soa_serial",
+			Help: "Last observed SOA serial for a domain/server pair.",
+		}, []string{"domain", "server"}),
+		maxLagSeconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_soa_max_lag_seconds",
+			Help: "Maximum observed serial lag across all servers for a domain.",
+		}, []string{"domain"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "dns_soa_query_duration_seconds",
+			Help: "Duration of SOA queries against a domain/server pair.",
+		}, []string{"domain", "server"}),
+		errorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns_soa_errors_total",
+			Help: "Count of errors encountered while monitoring a domain/server pair.",
+		}, []string{"domain", "server", "kind"}),
+		errorRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dns_soa_error_rate",
+			Help: "Rolling error rate across all monitored domains.",
+		}),
+		propagation: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_soa_propagation_seconds",
+			Help: "Time for all secondaries to converge after a NOTIFY-triggered sweep.",
+		}, []string{"domain"}),
+		rrsetCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_soa_rrset_count",
+			Help: "Number of non-SOA resource records seen in the last AXFR of a domain/server pair.",
+		}, []string{"domain", "server"}),
+		zoneHashMismatch: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_soa_zone_hash_mismatch",
+			Help: "1 if the last AXFR round found a zone content mismatch across a domain's servers, 0 otherwise.",
+		}, []string{"domain"}),
+	}
+
+	prometheus.MustRegister(s.lagSeconds, s.serial, s.maxLagSeconds, s.queryDuration, s.errorsTotal, s.errorRate, s.propagation, s.rrsetCount, s.zoneHashMismatch)
+
+	return s
+}
+
+func (s *PrometheusSink) RecordSerial(domainName, server string, serial uint32) {
+	s.serial.WithLabelValues(domainName, server).Set(float64(serial))
+}
+
+func (s *PrometheusSink) RecordLagSeconds(domainName, primaryServer, secondaryServer string, lagSeconds int64) {
+	s.lagSeconds.WithLabelValues(domainName, primaryServer, secondaryServer).Set(float64(lagSeconds))
+}
+
+func (s *PrometheusSink) RecordMaxLagSeconds(domainName string, lagSeconds int64) {
+	s.maxLagSeconds.WithLabelValues(domainName).Set(float64(lagSeconds))
+}
+
+func (s *PrometheusSink) RecordQueryDuration(domainName, server string, d time.Duration) {
+	s.queryDuration.WithLabelValues(domainName, server).Observe(d.Seconds())
+}
+
+func (s *PrometheusSink) RecordError(domainName, server, kind string) {
+	s.errorsTotal.WithLabelValues(domainName, server, kind).Inc()
+}
+
+func (s *PrometheusSink) RecordErrorRate(errorRate int64) {
+	s.errorRate.Set(float64(errorRate))
+}
+
+func (s *PrometheusSink) RecordPropagationSeconds(domainName string, seconds float64) {
+	s.propagation.WithLabelValues(domainName).Set(seconds)
+}
+
+func (s *PrometheusSink) RecordRRSetCount(domainName, server string, rrsetCount int) {
+	s.rrsetCount.WithLabelValues(domainName, server).Set(float64(rrsetCount))
+}
+
+func (s *PrometheusSink) RecordZoneHashMismatch(domainName string, mismatch bool) {
+	v := float64(0)
+	if mismatch {
+		v = 1
+	}
+	s.zoneHashMismatch.WithLabelValues(domainName).Set(v)
+}
+
+// MultiSink fans a single call out to every configured sink.
+type MultiSink []MetricsSink
+
+func (s MultiSink) RecordSerial(domainName, server string, serial uint32) {
+	for _, sink := range s {
+		sink.RecordSerial(domainName, server, serial)
+	}
+}
+
+func (s MultiSink) RecordLagSeconds(domainName, primaryServer, secondaryServer string, lagSeconds int64) {
+	for _, sink := range s {
+		sink.RecordLagSeconds(domainName, primaryServer, secondaryServer, lagSeconds)
+	}
+}
+
+func (s MultiSink) RecordMaxLagSeconds(domainName string, lagSeconds int64) {
+	for _, sink := range s {
+		sink.RecordMaxLagSeconds(domainName, lagSeconds)
+	}
+}
+
+func (s MultiSink) RecordQueryDuration(domainName, server string, d time.Duration) {
+	for _, sink := range s {
+		sink.RecordQueryDuration(domainName, server, d)
+	}
+}
+
+func (s MultiSink) RecordError(domainName, server, kind string) {
+	for _, sink := range s {
+		sink.RecordError(domainName, server, kind)
+	}
+}
+
+func (s MultiSink) RecordErrorRate(errorRate int64) {
+	for _, sink := range s {
+		sink.RecordErrorRate(errorRate)
+	}
+}
+
+func (s MultiSink) RecordPropagationSeconds(domainName string, seconds float64) {
+	for _, sink := range s {
+		sink.RecordPropagationSeconds(domainName, seconds)
+	}
+}
+
+func (s MultiSink) RecordRRSetCount(domainName, server string, rrsetCount int) {
+	for _, sink := range s {
+		sink.RecordRRSetCount(domainName, server, rrsetCount)
+	}
+}
+
+func (s MultiSink) RecordZoneHashMismatch(domainName string, mismatch bool) {
+	for _, sink := range s {
+		sink.RecordZoneHashMismatch(domainName, mismatch)
+	}
+}
+
+func startMetricsServer(listen string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{Addr: listen, Handler: mux}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const notifyConvergencePollInterval = 2 * time.Second
+
+var (
+	notifyListen    = ""
+	notifyTriggersM sync.Mutex
+	notifyTriggers  = make(map[string]chan struct{})
+)
+
+func loadNotifyConfig() {
+	notifyListen = os.Getenv("NOTIFY_LISTEN")
+}
+
+func registerNotifyTrigger(domainName string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	notifyTriggersM.Lock()
+	notifyTriggers[dns.Fqdn(domainName)] = ch
+	notifyTriggersM.Unlock()
+
+	return ch
+}
+
+// unregisterNotifyTrigger removes a domain's trigger channel so incoming
+// NOTIFY messages for it are dropped once its monitor has been cancelled.
+func unregisterNotifyTrigger(domainName string) {
+	notifyTriggersM.Lock()
+	delete(notifyTriggers, dns.Fqdn(domainName))
+	notifyTriggersM.Unlock()
+}
+
+func handleNotify(w dns.ResponseWriter, r *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	w.WriteMsg(resp)
+
+	if r.Opcode != dns.OpcodeNotify || len(r.Question) == 0 {
+		return
+	}
+
+	zone := r.Question[0].Name
+
+	notifyTriggersM.Lock()
+	ch, ok := notifyTriggers[zone]
+	notifyTriggersM.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func runNotifyListener(listen string) {
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", handleNotify)
+
+	server := &dns.Server{Addr: listen, Net: "udp", Handler: mux}
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// waitForConvergence re-sweeps domainName at a short interval until every
+// secondary has caught up to the highest primary serial observed, or until
+// timeout elapses.
+func waitForConvergence(domainName string, primaryServers, secondaryServers []string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	targetServers := []string{}
+	targetServers = append(targetServers, primaryServers...)
+	targetServers = append(targetServers, secondaryServers...)
+
+	for time.Now().Before(deadline) {
+		errs := make(chan serialError, len(targetServers))
+		serials := getSerials(domainName, targetServers, errs)
+		for se := range errs {
+			processError(logrus.Fields{"domain": domainName, "secondary": se.server}, se.err)
+		}
+
+		maxPrimarySerial := uint32(0)
+		havePrimary := false
+		for _, primaryServer := range primaryServers {
+			if serial, ok := serials[primaryServer]; ok {
+				havePrimary = true
+				if serial > maxPrimarySerial {
+					maxPrimarySerial = serial
+				}
+			}
+		}
+
+		if havePrimary {
+			converged := true
+			for _, secondaryServer := range secondaryServers {
+				serial, ok := serials[secondaryServer]
+				if !ok || serial < maxPrimarySerial {
+					converged = false
+					break
+				}
+			}
+
+			if converged {
+				return true
+			}
+		}
+
+		time.Sleep(notifyConvergencePollInterval)
+	}
+
+	return false
+}
+
+func runPropagationMonitor(ctx context.Context, domainName string, primaryServers, secondaryServers []string, triggers <-chan struct{}) {
+	defer unregisterNotifyTrigger(domainName)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-triggers:
+		}
+
+		start := time.Now()
+
+		if waitForConvergence(domainName, primaryServers, secondaryServers, 5*time.Minute) {
+			elapsed := time.Since(start)
+
+			logger.WithFields(logrus.Fields{"domain": domainName, "propagation_seconds": elapsed.Seconds()}).Debug("propagation converged")
+
+			sink.RecordPropagationSeconds(domainName, elapsed.Seconds())
+		} else {
+			processError(logrus.Fields{"domain": domainName}, errors.Errorf("propagation tracking for %v did not converge within timeout", domainName))
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// dohClient bounds DoH requests to the same timeout the dns.Client default
+// dialer uses for udp/tcp/tls, so a hung DoH endpoint can't block a domain's
+// monitor loop forever.
+var dohClient = &http.Client{Timeout: 2 * time.Second}
+
+// serverTransport describes how to reach a single configured server, parsed
+// once from its scheme (e.g. "tls://ns1.example.com:853") and cached for
+// reuse across polls.
+type serverTransport struct {
+	scheme string // "udp", "tcp", "tls", or "https"
+	addr   string // host:port for udp/tcp/tls, full URL for https
+	client *dns.Client
+}
+
+var transportCache sync.Map // server string -> *serverTransport
+
+func stripPort(hostport string) string {
+	if i := strings.LastIndex(hostport, ":"); i != -1 {
+		return hostport[:i]
+	}
+	return hostport
+}
+
+func parseServerTransport(server string) *serverTransport {
+	if cached, ok := transportCache.Load(server); ok {
+		return cached.(*serverTransport)
+	}
+
+	var t *serverTransport
+
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		t = &serverTransport{scheme: "https", addr: server}
+	case strings.HasPrefix(server, "tls://"):
+		host := strings.TrimPrefix(server, "tls://")
+		if !strings.Contains(host, ":") {
+			host += ":853"
+		}
+		t = &serverTransport{
+			scheme: "tls",
+			addr:   host,
+			client: &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{ServerName: stripPort(host)}},
+		}
+	case strings.HasPrefix(server, "tcp://"):
+		host := strings.TrimPrefix(server, "tcp://")
+		if !strings.Contains(host, ":") {
+			host += ":53"
+		}
+		t = &serverTransport{scheme: "tcp", addr: host, client: &dns.Client{Net: "tcp"}}
+	default:
+		host := server
+		if !strings.Contains(host, ":") {
+			host += ":53"
+		}
+		t = &serverTransport{scheme: "udp", addr: host, client: &dns.Client{Net: "udp"}}
+	}
+
+	transportCache.Store(server, t)
+	return t
+}
+
+func exchangeViaDoH(req *dns.Msg, url string) (*dns.Msg, error) {
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to pack dns message")
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to build doh request")
+	}
+	httpReq.Header.Set("Content-Type", "application/dns-message")
+	httpReq.Header.Set("Accept", "application/dns-message")
+
+	resp, err := dohClient.Do(httpReq)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to perform doh request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("doh request to %v returned status %v", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read doh response body")
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, errors.Wrapf(err, "failed to unpack doh response")
+	}
+
+	return r, nil
+}
+
+func exchange(req *dns.Msg, server string) (*dns.Msg, error) {
+	t := parseServerTransport(server)
+
+	if t.scheme == "https" {
+		return exchangeViaDoH(req, t.addr)
+	}
+
+	r, _, err := t.client.Exchange(req, t.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to exchange over %v", t.scheme)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestParseServerTransportSchemes(t *testing.T) {
+	cases := []struct {
+		server       string
+		wantScheme   string
+		wantAddr     string
+		wantNoClient bool
+	}{
+		{"ns1.example.com", "udp", "ns1.example.com:53", false},
+		{"ns1.example.com:5353", "udp", "ns1.example.com:5353", false},
+		{"tcp://ns1.example.com", "tcp", "ns1.example.com:53", false},
+		{"tcp://ns1.example.com:5353", "tcp", "ns1.example.com:5353", false},
+		{"tls://ns1.example.com", "tls", "ns1.example.com:853", false},
+		{"tls://ns1.example.com:8530", "tls", "ns1.example.com:8530", false},
+		{"https://dns.example.com/dns-query", "https", "https://dns.example.com/dns-query", true},
+	}
+
+	for _, c := range cases {
+		got := parseServerTransport(c.server)
+		if got.scheme != c.wantScheme {
+			t.Errorf("parseServerTransport(%q).scheme = %q, want %q", c.server, got.scheme, c.wantScheme)
+		}
+		if got.addr != c.wantAddr {
+			t.Errorf("parseServerTransport(%q).addr = %q, want %q", c.server, got.addr, c.wantAddr)
+		}
+		if c.wantNoClient && got.client != nil {
+			t.Errorf("parseServerTransport(%q).client = %v, want nil", c.server, got.client)
+		}
+		if !c.wantNoClient && got.client == nil {
+			t.Errorf("parseServerTransport(%q).client = nil, want non-nil", c.server)
+		}
+	}
+}
+
+func TestParseServerTransportCachesResult(t *testing.T) {
+	server := "cache-test.example.com:53"
+
+	first := parseServerTransport(server)
+	second := parseServerTransport(server)
+
+	if first != second {
+		t.Errorf("parseServerTransport(%q) returned distinct instances on repeated calls, want cached pointer", server)
+	}
+}